@@ -15,138 +15,210 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"net/netip"
 	"strconv"
 	"strings"
+
+	"github.com/tommid/gosnmp/internal/berutil"
 )
 
 // -- helper functions (mostly) in alphabetical order --------------------------
 
-func decodeValue(data []byte, msg string) (retVal *Variable, err error) {
-	dumpBytes1(data, fmt.Sprintf("decodeValue: %s", msg), 16)
-	retVal = new(Variable)
+// DecodeScratch holds buffers that decodeVarbinds reuses across a walk of
+// many values, so decoding an OBJECT IDENTIFIER doesn't allocate a fresh
+// []int for every value the way a one-shot decodeValue call does.
+type DecodeScratch struct {
+	oid []int
+}
 
-	switch Asn1BER(data[0]) {
+// decodeValue decodes a single BER-encoded value and returns it as a
+// freshly allocated Variable. It's a convenience wrapper around
+// decodeValueInto for callers that only have one value to decode; a
+// caller walking many values - a GetBulk reply's worth, say - should use
+// decodeVarbinds instead, which reuses one Decoder and one DecodeScratch
+// across the whole walk rather than paying for a new one here each time.
+func decodeValue(data []byte, msg string) (*Variable, error) {
+	return decodeValueInto(berutil.NewDecoder(data), new(DecodeScratch), new(Variable))
+}
 
-	case Integer:
-		// 0x02. signed
-		slog.Print("decodeValue: type is Integer")
-		length, cursor := parseLength(data)
-		if ret, err := parseInt(data[cursor:length]); err != nil {
-			slog.Printf("%v:", err)
-			return retVal, fmt.Errorf("bytes: % x err: %v", data, err)
-		} else {
-			retVal.Type = Integer
-			retVal.Value = ret
-		}
-	case OctetString:
-		// 0x04
-		slog.Print("decodeValue: type is OctetString")
-		length, cursor := parseLength(data)
-		retVal.Type = OctetString
-		if data[cursor] == 0 && length == 2 {
-			retVal.Value = ""
-		} else if data[cursor] == 0 {
-			retVal.Value = fmt.Sprintf("% x", data[cursor:length])
-		} else {
-			retVal.Value = string(data[cursor:length])
-		}
-	case Null:
-		// 0x05
-		slog.Print("decodeValue: type is Null")
-		retVal.Type = Null
-		retVal.Value = nil
-	case ObjectIdentifier:
-		// 0x06
-		slog.Print("decodeValue: type is ObjectIdentifier")
-		rawOid, _, err := parseRawField(data, "OID")
-		if err != nil {
-			return nil, fmt.Errorf("Error parsing OID Value: %s", err.Error())
-		}
-		var oid []int
-		var ok bool
-		if oid, ok = rawOid.([]int); !ok {
-			return nil, fmt.Errorf("unable to type assert rawOid |%v| to []int", rawOid)
-		}
-		retVal.Type = ObjectIdentifier
-		retVal.Value = oidToString(oid)
-	case IpAddress:
-		// 0x40
-		slog.Print("decodeValue: type is IpAddress")
-		// total hack - IPv6? What IPv6...
-		if len(data) < 6 {
-			return nil, fmt.Errorf("not enough data for ipaddress: % x", data)
-		} else if data[1] != 4 {
-			return nil, fmt.Errorf("got ipaddress len %d, expected 4", data[1])
-		}
-		retVal.Type = IpAddress
-		var ipv4 string
-		for i := 2; i < 6; i++ {
-			ipv4 += fmt.Sprintf(".%d", data[i])
-		}
-		retVal.Value = ipv4[1:]
-	case Counter32:
-		// 0x41. unsigned
-		slog.Print("decodeValue: type is Counter32")
-		length, cursor := parseLength(data)
-		ret, err := parseUint(data[cursor:length])
-		if err != nil {
-			slog.Printf("decodeValue: err is %v", err)
-			break
-		}
-		retVal.Type = Counter32
-		retVal.Value = ret
-	case Gauge32:
-		// 0x42. unsigned
-		slog.Print("decodeValue: type is Gauge32")
-		length, cursor := parseLength(data)
-		ret, err := parseUint(data[cursor:length])
-		if err != nil {
-			slog.Printf("decodeValue: err is %v", err)
-			break
+// decodeValueInto reads one value from dec into retVal, which the caller
+// owns - typically a slot in a pre-allocated []Variable - using scratch's
+// buffers as the backing array for anything that would otherwise need its
+// own allocation, such as an OBJECT IDENTIFIER's []int.
+func decodeValueInto(dec *berutil.Decoder, scratch *DecodeScratch, retVal *Variable) (retVariable *Variable, err error) {
+	retVariable = retVal
+
+	// berutil.Decoder only dumps hex when Debug is set, so a MIB walk of
+	// thousands of varbinds doesn't pay for dumpBytes1 on every value.
+	raw, err := dec.ReadRaw()
+	if err != nil {
+		return nil, fmt.Errorf("decodeValue: %s", err.Error())
+	}
+
+	switch raw.Class {
+
+	case berutil.ClassUniversal:
+		switch Asn1BER(raw.Tag) {
+		case Integer:
+			// 0x02. signed
+			slog.Print("decodeValue: type is Integer")
+			if ret, err := parseInt(raw.Bytes); err != nil {
+				slog.Printf("%v:", err)
+				return retVal, fmt.Errorf("bytes: % x err: %v", raw.FullBytes, err)
+			} else {
+				retVal.Type = Integer
+				retVal.Value = ret
+			}
+		case OctetString:
+			// 0x04
+			slog.Print("decodeValue: type is OctetString")
+			retVal.Type = OctetString
+			if len(raw.Bytes) == 0 {
+				retVal.Value = ""
+			} else if raw.Bytes[0] == 0 {
+				retVal.Value = fmt.Sprintf("% x", raw.Bytes)
+			} else {
+				retVal.Value = string(raw.Bytes)
+			}
+		case Null:
+			// 0x05
+			slog.Print("decodeValue: type is Null")
+			retVal.Type = Null
+			retVal.Value = nil
+		case ObjectIdentifier:
+			// 0x06. dec.ReadOID reuses scratch.oid's backing array across
+			// a decodeVarbinds walk instead of allocating a fresh []int
+			// per value the way parseObjectIdentifier(raw.Bytes) would.
+			slog.Print("decodeValue: type is ObjectIdentifier")
+			oid, err := dec.ReadOID(scratch.oid)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing OID Value: %s", err.Error())
+			}
+			scratch.oid = oid
+			retVal.Type = ObjectIdentifier
+			retVal.Value = oidToString(oid)
+		default:
+			slog.Print("decodeValue: type isn't implemented")
+			err = fmt.Errorf("Unable to decode %x - not implemented", raw.FullBytes[0])
 		}
-		retVal.Type = Gauge32
-		retVal.Value = ret
-	case TimeTicks:
-		// 0x43
-		slog.Print("decodeValue: type is TimeTicks")
-		length, cursor := parseLength(data)
-		ret, err := parseInt(data[cursor:length])
-		if err != nil {
-			slog.Printf("decodeValue: err is %v", err)
-			break
+
+	case berutil.ClassApplication:
+		switch raw.Tag {
+		case berutil.TagIPAddress:
+			// 0x40. IpAddress, or (RFC 4001) an InetAddress carrying an
+			// IPv4 or IPv6 address, optionally zone-scoped.
+			slog.Print("decodeValue: type is IpAddress")
+			addr, legacy, err := parseInetAddress(raw.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			retVal.Type = IpAddress
+			retVal.IPAddr = addr
+			retVal.Value = legacy
+		case berutil.TagCounter32:
+			// 0x41. unsigned
+			slog.Print("decodeValue: type is Counter32")
+			ret, err := parseUint(raw.Bytes)
+			if err != nil {
+				slog.Printf("decodeValue: err is %v", err)
+				break
+			}
+			retVal.Type = Counter32
+			retVal.Value = ret
+		case berutil.TagGauge32:
+			// 0x42. unsigned
+			slog.Print("decodeValue: type is Gauge32")
+			ret, err := parseUint(raw.Bytes)
+			if err != nil {
+				slog.Printf("decodeValue: err is %v", err)
+				break
+			}
+			retVal.Type = Gauge32
+			retVal.Value = ret
+		case berutil.TagTimeTicks:
+			// 0x43
+			slog.Print("decodeValue: type is TimeTicks")
+			ret, err := parseInt(raw.Bytes)
+			if err != nil {
+				slog.Printf("decodeValue: err is %v", err)
+				break
+			}
+			retVal.Type = TimeTicks
+			retVal.Value = ret
+		case berutil.TagOpaque:
+			// 0x44. RFC 2856 opaque special syntax: nests a Float32,
+			// Float64, Int64 or Uint64 inside a further tag/length/value.
+			slog.Print("decodeValue: type is Opaque")
+			ret, err := parseOpaqueFloat(raw.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			retVal.Type = Opaque
+			retVal.Value = ret
+		case berutil.TagCounter64:
+			// 0x46
+			slog.Print("decodeValue: type is Counter64")
+			ret, err := parseInt64(raw.Bytes)
+			if err != nil {
+				slog.Printf("decodeValue: err is %v", err)
+				break
+			}
+			retVal.Type = Counter64
+			retVal.Value = ret
+		default:
+			slog.Print("decodeValue: type isn't implemented")
+			err = fmt.Errorf("Unable to decode %x - not implemented", raw.FullBytes[0])
 		}
-		retVal.Type = TimeTicks
-		retVal.Value = ret
-	case Counter64:
-		// 0x46
-		slog.Print("decodeValue: type is Counter64")
-		length, cursor := parseLength(data)
-		ret, err := parseInt64(data[cursor:length])
-		if err != nil {
-			slog.Printf("decodeValue: err is %v", err)
-			break
+
+	case berutil.ClassContext:
+		switch raw.Tag {
+		case berutil.TagNoSuchObject:
+			// 0x80
+			slog.Print("decodeValue: type is NoSuchObject")
+			retVal.Type = NoSuchObject
+			retVal.Value = nil
+		case berutil.TagNoSuchInstance:
+			// 0x81
+			slog.Print("decodeValue: type is NoSuchInstance")
+			retVal.Type = NoSuchInstance
+			retVal.Value = nil
+		case berutil.TagEndOfMibView:
+			// 0x82
+			slog.Print("decodeValue: type is EndOfMibView")
+			retVal.Type = EndOfMibView
+			retVal.Value = nil
+		default:
+			slog.Print("decodeValue: type isn't implemented")
+			err = fmt.Errorf("Unable to decode %x - not implemented", raw.FullBytes[0])
 		}
-		retVal.Type = Counter64
-		retVal.Value = ret
-	case NoSuchObject:
-		// 0x80
-		slog.Print("decodeValue: type is NoSuchObject")
-		retVal.Type = NoSuchObject
-		retVal.Value = nil
-	case NoSuchInstance:
-		// 0x81
-		slog.Print("decodeValue: type is NoSuchInstance")
-		retVal.Type = NoSuchInstance
-		retVal.Value = nil
+
 	default:
 		slog.Print("decodeValue: type isn't implemented")
-		err = fmt.Errorf("Unable to decode %x - not implemented", data[0])
+		err = fmt.Errorf("Unable to decode %x - not implemented", raw.FullBytes[0])
 	}
 
 	slog.Printf("decodeValue: value is %#v", retVal.Value)
 	return
 }
 
+// decodeVarbinds decodes a buffer of back-to-back encoded values - as a
+// GetBulk-scale walk encounters - into dst, one value per slot. It walks
+// a single berutil.Decoder and a single DecodeScratch across the whole
+// buffer, so it avoids the fresh Decoder, Variable and OID slice that
+// calling decodeValue once per value would allocate. The values it stores
+// (OID and OctetString strings, boxed integers) are still allocated per
+// value.
+func decodeVarbinds(data []byte, dst []Variable) error {
+	dec := berutil.NewDecoder(data)
+	scratch := new(DecodeScratch)
+	for i := range dst {
+		if _, err := decodeValueInto(dec, scratch, &dst[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // dump bytes in a format similar to Wireshark
 func dumpBytes1(data []byte, msg string, maxlength int) {
 	for i, b := range data {
@@ -207,6 +279,48 @@ func marshalBase128Int(out *bytes.Buffer, n int64) (err error) {
 	return nil
 }
 
+// marshalBitString is the marshal counterpart of parseBitString: it
+// prepends the padding-bit count byte, mirroring the same padding-bit
+// rules that are enforced when a BIT STRING is read back in.
+func marshalBitString(b BitStringValue) ([]byte, error) {
+	padding := 0
+	if b.BitLength%8 != 0 {
+		padding = 8 - b.BitLength%8
+	}
+	if wantBytes := (b.BitLength + 7) / 8; len(b.Bytes) != wantBytes {
+		return nil, fmt.Errorf("marshalBitString: BitLength %d needs %d bytes, got %d", b.BitLength, wantBytes, len(b.Bytes))
+	}
+	if padding > 0 && len(b.Bytes) > 0 && b.Bytes[len(b.Bytes)-1]&((1<<uint(padding))-1) != 0 {
+		return nil, errors.New("marshalBitString: non-zero padding bits")
+	}
+
+	ret := make([]byte, 0, len(b.Bytes)+1)
+	ret = append(ret, byte(padding))
+	ret = append(ret, b.Bytes...)
+	return ret, nil
+}
+
+// marshalInetAddress encodes addr as an RFC 4001 InetAddress payload: 4
+// bytes for IPv4, 16 for IPv6. It's the marshal counterpart of
+// parseInetAddress, so that a Set PDU can carry an IPv6 (or IPv4)
+// address. It does not produce the zone-scoped 8- and 20-byte forms that
+// parseInetAddress accepts: an address with a zone is rejected.
+func marshalInetAddress(addr netip.Addr) ([]byte, error) {
+	if addr.Zone() != "" {
+		return nil, fmt.Errorf("marshalInetAddress: zoned address %s has no wire representation", addr)
+	}
+	switch {
+	case addr.Is4() || addr.Is4In6():
+		b := addr.As4()
+		return b[:], nil
+	case addr.Is6():
+		b := addr.As16()
+		return b[:], nil
+	default:
+		return nil, fmt.Errorf("marshalInetAddress: invalid address %s", addr)
+	}
+}
+
 // marshalLength builds a byte representation of length
 //
 // http://luca.ntop.org/Teaching/Appunti/asn1.html
@@ -223,33 +337,44 @@ func marshalLength(length int) ([]byte, error) {
 	// more convenient to pass length as int than uint64. Therefore check < 0
 	if length < 0 {
 		return nil, fmt.Errorf("length must be greater than zero")
-	} else if length < 127 {
+	} else if length < 128 {
 		return []byte{byte(length)}, nil
 	}
 
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, uint64(length))
-	if err != nil {
-		return nil, err
+	// Long form: a header byte (0x80 | n) followed by the length itself as
+	// n big-endian, minimally-encoded octets. n is found the same way
+	// encoding/asn1's marshalLength finds it - by counting how many bytes
+	// are needed to hold length, most significant non-zero byte first.
+	n := 0
+	for i := length; i > 0; i >>= 8 {
+		n++
 	}
 
-	buf_bytes, err2 := buf.ReadBytes(0) // can't use buf.Bytes() - trailing 00's
-	if err2 != nil {
-		return nil, err
+	ret := make([]byte, n+1)
+	ret[0] = byte(0x80 | n)
+	for i := n; i > 0; i-- {
+		ret[i] = byte(length)
+		length >>= 8
 	}
-	buf_bytes = buf_bytes[0 : len(buf_bytes)-1] // remove trailing 00
-
-	header := []byte{byte(128 | len(buf_bytes))}
-	return append(header, buf_bytes...), nil
+	return ret, nil
 }
 
+// marshalObjectIdentifier encodes oid's arcs as BER base-128 integers.
+// Per X.690 the first two arcs are combined into a single joint
+// identifier 40*X1 + X2, where X1 must be 0, 1 or 2; X2 is only bounded
+// to <40 when X1 < 2 (X1 == 2 is used for joint identifiers like
+// "2.100.x" and leaves X2 unbounded). Sub-identifiers - including the
+// joint one - may use the full uint32 range, as RFC 2578 allows.
 func marshalObjectIdentifier(oid []int) (ret []byte, err error) {
 	out := new(bytes.Buffer)
-	if len(oid) < 2 || oid[0] > 6 || oid[1] >= 40 {
+	if len(oid) < 2 || oid[0] < 0 || oid[0] > 2 || oid[1] < 0 {
+		return nil, errors.New("invalid object identifier")
+	}
+	if oid[0] < 2 && oid[1] >= 40 {
 		return nil, errors.New("invalid object identifier")
 	}
 
-	err = out.WriteByte(byte(oid[0]*40 + oid[1]))
+	err = marshalBase128Int(out, int64(oid[0])*40+int64(oid[1]))
 	if err != nil {
 		return
 	}
@@ -289,6 +414,53 @@ func marshalOID(oid string) ([]byte, error) {
 	return mOid, err
 }
 
+// Inner tags nested inside an SNMP Opaque (RFC 2856 section 3.1): the
+// opaque special syntax wraps a Float32, Float64, Int64 or Uint64 in a
+// further tag/length/value triplet rather than exposing a dedicated
+// top-level BER tag for each.
+const (
+	opaqueFloatTag  = 0x78
+	opaqueDoubleTag = 0x79
+	opaqueInt64Tag  = 0x80
+	opaqueUint64Tag = 0x81
+)
+
+// marshalOpaqueFloat is the marshal counterpart of parseOpaqueFloat: it
+// encodes v as an RFC 2856 opaque special-syntax payload (nested tag,
+// length, big-endian value). The caller wraps the result in the outer
+// Opaque (0x44) TLV.
+func marshalOpaqueFloat(v interface{}) ([]byte, error) {
+	var tag byte
+	var body []byte
+	switch n := v.(type) {
+	case float32:
+		tag = opaqueFloatTag
+		body = make([]byte, 4)
+		binary.BigEndian.PutUint32(body, math.Float32bits(n))
+	case float64:
+		tag = opaqueDoubleTag
+		body = make([]byte, 8)
+		binary.BigEndian.PutUint64(body, math.Float64bits(n))
+	case int64:
+		tag = opaqueInt64Tag
+		body = make([]byte, 8)
+		binary.BigEndian.PutUint64(body, uint64(n))
+	case uint64:
+		tag = opaqueUint64Tag
+		body = make([]byte, 8)
+		binary.BigEndian.PutUint64(body, n)
+	default:
+		return nil, fmt.Errorf("marshalOpaqueFloat: unsupported type %T", v)
+	}
+
+	length, err := marshalLength(len(body))
+	if err != nil {
+		return nil, err
+	}
+	ret := append([]byte{tag}, length...)
+	return append(ret, body...), nil
+}
+
 func oidToString(oid []int) (ret string) {
 	for _, i := range oid {
 		ret = ret + fmt.Sprintf(".%d", i)
@@ -296,27 +468,6 @@ func oidToString(oid []int) (ret string) {
 	return ret[1:]
 }
 
-// parseBase128Int parses a base-128 encoded int from the given offset in the
-// given byte slice. It returns the value and the new offset.
-func parseBase128Int(bytes []byte, initOffset int) (ret, offset int, err error) {
-	offset = initOffset
-	for shifted := 0; offset < len(bytes); shifted++ {
-		if shifted > 4 {
-			err = fmt.Errorf("Structural Error: base 128 integer too large")
-			return
-		}
-		ret <<= 7
-		b := bytes[offset]
-		ret |= int(b & 0x7f)
-		offset++
-		if b&0x80 == 0 {
-			return
-		}
-	}
-	err = fmt.Errorf("Syntax Error: truncated base 128 integer")
-	return
-}
-
 // parseBitString parses an ASN.1 bit string from the given byte slice and returns it.
 func parseBitString(bytes []byte) (ret BitStringValue, err error) {
 	if len(bytes) == 0 {
@@ -335,25 +486,48 @@ func parseBitString(bytes []byte) (ret BitStringValue, err error) {
 	return
 }
 
-// parseInt64 treats the given bytes as a big-endian, signed integer and
-// returns the result.
-func parseInt64(bytes []byte) (ret int64, err error) {
-	if len(bytes) > 8 {
-		// We'll overflow an int64 in this case.
-		err = errors.New("integer too large")
-		return
-	}
-	for bytesRead := 0; bytesRead < len(bytes); bytesRead++ {
-		ret <<= 8
-		ret |= int64(bytes[bytesRead])
+// parseInetAddress decodes an RFC 4001 InetAddress textual convention
+// payload. The encoding is implied by its length: 4 bytes is a plain
+// IPv4 address, 16 is IPv6, and the "z" variants (InetAddressIPv4z,
+// InetAddressIPv6z - 8 and 20 bytes respectively) append a trailing
+// 4-byte zone index. It returns both the parsed netip.Addr and the
+// legacy dotted/colon string form of it.
+func parseInetAddress(data []byte) (addr netip.Addr, legacy string, err error) {
+	switch len(data) {
+	case 4:
+		addr = netip.AddrFrom4([4]byte(data))
+		legacy = addr.String()
+	case 16:
+		addr = netip.AddrFrom16([16]byte(data))
+		legacy = addr.String()
+	case 8:
+		// InetAddressIPv4z. net/netip has no notion of a zoned IPv4
+		// address, so the zone only survives into the legacy string.
+		var b [4]byte
+		copy(b[:], data[:4])
+		zone := binary.BigEndian.Uint32(data[4:8])
+		addr = netip.AddrFrom4(b)
+		legacy = fmt.Sprintf("%s%%%d", addr, zone)
+	case 20:
+		// InetAddressIPv6z.
+		var b [16]byte
+		copy(b[:], data[:16])
+		zone := binary.BigEndian.Uint32(data[16:20])
+		addr = netip.AddrFrom16(b).WithZone(strconv.FormatUint(uint64(zone), 10))
+		legacy = addr.String()
+	default:
+		err = fmt.Errorf("got ipaddress len %d, expected 4, 8, 16 or 20", len(data))
 	}
-
-	// Shift up and down in order to sign extend the result.
-	ret <<= 64 - uint8(len(bytes))*8
-	ret >>= 64 - uint8(len(bytes))*8
 	return
 }
 
+// parseInt64 treats the given bytes as a big-endian, signed integer and
+// returns the result. It's a thin wrapper around berutil.ParseInt64,
+// which owns the actual decode since it's generic ASN.1, not SNMP-specific.
+func parseInt64(bytes []byte) (int64, error) {
+	return berutil.ParseInt64(bytes)
+}
+
 // parseInt treats the given bytes as a big-endian, signed integer and returns
 // the result.
 func parseInt(bytes []byte) (int, error) {
@@ -378,80 +552,120 @@ func parseInt(bytes []byte) (int, error) {
 // * Long form. Two to 127 octets. Bit 8 of first octet has value "1" and bits
 //   7-1 give the number of additional length octets. Second and following
 //   octets give the length, base 256, most significant digit first.
-func parseLength(bytes []byte) (length int, cursor int) {
-	if len(bytes) <= 2 {
-		// handle null octet strings ie "0x04 0x00"
-		cursor = 1
-		length = 2
-	} else if int(bytes[1]) <= 127 {
-		length = int(bytes[1])
-		length += 2
-		cursor += 2
+//
+// A truncated long-form header, an indefinite length, or a length that
+// doesn't fit in the remaining buffer is reported as an error.
+func parseLength(bytes []byte) (length int, cursor int, err error) {
+	if len(bytes) < 2 {
+		return 0, 0, fmt.Errorf("parseLength: need at least 2 bytes, got %d", len(bytes))
+	}
+
+	if int(bytes[1]) <= 127 {
+		length = int(bytes[1]) + 2
+		cursor = 2
 	} else {
 		num_octets := int(bytes[1]) & 127
+		if num_octets == 0 {
+			return 0, 0, errors.New("parseLength: indefinite length form is not supported")
+		}
+		if num_octets > 8 {
+			// More octets than fit in an int without overflowing: no real
+			// SNMP packet needs a multi-exabyte length, and letting the
+			// shift loop below run past this would silently wrap length
+			// negative, defeating the "length > len(bytes)" bounds check.
+			return 0, 0, fmt.Errorf("parseLength: long-form length of %d octets too large", num_octets)
+		}
+		if 2+num_octets > len(bytes) {
+			return 0, 0, errors.New("parseLength: truncated long-form length header")
+		}
 		for i := 0; i < num_octets; i++ {
 			length <<= 8
 			length += int(bytes[2+i])
 		}
+		// Check against the remaining buffer - and reject a negative
+		// (wrapped) length - before adding the header size below: a
+		// content length near MaxInt would otherwise overflow that
+		// addition the same way it just overflowed the shift loop.
+		if length < 0 || length > len(bytes)-(2+num_octets) {
+			return 0, 0, fmt.Errorf("parseLength: length exceeds %d remaining bytes", len(bytes)-(2+num_octets))
+		}
 		length += 2 + num_octets
-		cursor += 2 + num_octets
+		cursor = 2 + num_octets
 	}
-	return length, cursor
+
+	if length > len(bytes) {
+		return 0, 0, fmt.Errorf("parseLength: length %d exceeds %d remaining bytes", length, len(bytes))
+	}
+	return length, cursor, nil
 }
 
 // parseObjectIdentifier parses an OBJECT IDENTIFIER from the given bytes and
 // returns it. An object identifier is a sequence of variable length integers
-// that are assigned in a hierarchy.
-func parseObjectIdentifier(bytes []byte) (s []int, err error) {
-	if len(bytes) == 0 {
-		err = fmt.Errorf("zero length OBJECT IDENTIFIER")
-		return
-	}
+// that are assigned in a hierarchy. It's a thin wrapper around
+// berutil.ParseObjectIdentifier, which owns the actual decode since it's
+// generic ASN.1, not SNMP-specific; decodeValueInto reaches it through
+// Decoder.ReadOID with a reusable scratch slice instead of allocating one
+// per OID.
+func parseObjectIdentifier(bytes []byte) ([]int, error) {
+	return berutil.ParseObjectIdentifier(bytes, nil)
+}
 
-	// In the worst case, we get two elements from the first byte (which is
-	// encoded differently) and then every varint is a single byte long.
-	s = make([]int, len(bytes)+1)
+// parseOpaqueFloat unwraps an RFC 2856 opaque special-syntax payload: a
+// nested tag/length header identifying a Float32, Float64, Int64 or
+// Uint64, followed by its big-endian value bytes.
+func parseOpaqueFloat(data []byte) (interface{}, error) {
+	length, cursor, err := parseLength(data)
+	if err != nil {
+		return nil, fmt.Errorf("opaque payload: %w", err)
+	}
+	body := data[cursor:length]
 
-	// The first byte is 40*value1 + value2:
-	s[0] = int(bytes[0]) / 40
-	s[1] = int(bytes[0]) % 40
-	i := 2
-	for offset := 1; offset < len(bytes); i++ {
-		var v int
-		v, offset, err = parseBase128Int(bytes, offset)
-		if err != nil {
-			return
+	switch data[0] {
+	case opaqueFloatTag:
+		if len(body) != 4 {
+			return nil, fmt.Errorf("opaque float32: expected 4 bytes, got %d", len(body))
 		}
-		s[i] = v
+		return math.Float32frombits(binary.BigEndian.Uint32(body)), nil
+	case opaqueDoubleTag:
+		if len(body) != 8 {
+			return nil, fmt.Errorf("opaque float64: expected 8 bytes, got %d", len(body))
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(body)), nil
+	case opaqueInt64Tag:
+		return parseInt64(body)
+	case opaqueUint64Tag:
+		return parseUint64(body)
+	default:
+		return nil, fmt.Errorf("unknown opaque inner tag %#x", data[0])
 	}
-	s = s[0:i]
-	return
 }
 
 func parseRawField(data []byte, msg string) (interface{}, int, error) {
-	dumpBytes1(data, fmt.Sprintf("parseRawField: %s", msg), 16)
+	dec := berutil.NewDecoder(data)
+	raw, err := dec.ReadRaw()
+	if err != nil {
+		return nil, 0, err
+	}
+	consumed := len(raw.FullBytes)
 
-	switch Asn1BER(data[0]) {
+	switch Asn1BER(raw.FullBytes[0]) {
 	case Integer:
-		length := int(data[1])
-		if length == 1 {
-			return int(data[2]), 3, nil
-		} else {
-			resp, err := parseUint(data[2:(2 + length)])
-			return resp, 2 + length, err
+		if len(raw.Bytes) == 1 {
+			return int(raw.Bytes[0]), consumed, nil
 		}
+		resp, err := parseUint(raw.Bytes)
+		return resp, consumed, err
 	case OctetString:
-		length, cursor := parseLength(data)
-		return string(data[cursor:length]), length, nil
+		return string(raw.Bytes), consumed, nil
 	case ObjectIdentifier:
-		length := int(data[1])
-		oid, err := parseObjectIdentifier(data[2 : 2+length])
-		return oid, length + 2, err
+		oid, err := parseObjectIdentifier(raw.Bytes)
+		return oid, consumed, err
+	case Opaque:
+		val, err := parseOpaqueFloat(raw.Bytes)
+		return val, consumed, err
 	default:
 		return nil, 0, fmt.Errorf("Unknown field type: %x\n", data[0])
 	}
-
-	return nil, 0, nil
 }
 
 func parseUint16(content []byte) int {
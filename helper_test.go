@@ -0,0 +1,283 @@
+// Copyright 2012 Andreas Louca, 2013 Sonia Hamilton. All rights reserved.  Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package gosnmp
+
+import (
+	"reflect"
+	"testing"
+)
+
+// decodeValueTests mirrors the style of encoding/asn1's marshal test
+// tables: each case supplies the wire bytes for a single BER value and
+// the Type/Value decodeValue should produce for it.
+var decodeValueTests = []struct {
+	in        []byte
+	wantType  Asn1BER
+	wantValue interface{}
+}{
+	{[]byte{0x02, 0x01, 0x2a}, Integer, 42},
+	{[]byte{0x04, 0x00}, OctetString, ""},
+	{[]byte{0x04, 0x03, 'f', 'o', 'o'}, OctetString, "foo"},
+	{[]byte{0x05, 0x00}, Null, nil},
+	{[]byte{0x06, 0x03, 0x2b, 0x06, 0x01}, ObjectIdentifier, "1.3.6.1"},
+}
+
+func TestDecodeValue(t *testing.T) {
+	for _, tt := range decodeValueTests {
+		got, err := decodeValue(tt.in, "test")
+		if err != nil {
+			t.Errorf("decodeValue(% x): %v", tt.in, err)
+			continue
+		}
+		if got.Type != tt.wantType {
+			t.Errorf("decodeValue(% x): Type = %v, want %v", tt.in, got.Type, tt.wantType)
+		}
+		if !reflect.DeepEqual(got.Value, tt.wantValue) {
+			t.Errorf("decodeValue(% x): Value = %#v, want %#v", tt.in, got.Value, tt.wantValue)
+		}
+	}
+}
+
+func TestParseRawFieldObjectIdentifier(t *testing.T) {
+	oid := []byte{0x06, 0x03, 0x2b, 0x06, 0x01}
+	got, n, err := parseRawField(oid, "test")
+	if err != nil {
+		t.Fatalf("parseRawField: %v", err)
+	}
+	if n != len(oid) {
+		t.Errorf("parseRawField consumed %d bytes, want %d", n, len(oid))
+	}
+	if !reflect.DeepEqual(got, []int{1, 3, 6, 1}) {
+		t.Errorf("parseRawField: got %v, want [1 3 6 1]", got)
+	}
+}
+
+// bitStringRoundTripTests covers the zero-length, byte-aligned and
+// padded cases marshalBitString/parseBitString need to agree on.
+var bitStringRoundTripTests = []BitStringValue{
+	{Bytes: []byte{}, BitLength: 0},
+	{Bytes: []byte{0xf0}, BitLength: 4},
+	{Bytes: []byte{0xff, 0x80}, BitLength: 9},
+	{Bytes: []byte{0xff, 0xff}, BitLength: 16},
+}
+
+func TestBitStringRoundTrip(t *testing.T) {
+	for _, bs := range bitStringRoundTripTests {
+		enc, err := marshalBitString(bs)
+		if err != nil {
+			t.Fatalf("marshalBitString(%+v): %v", bs, err)
+		}
+		got, err := parseBitString(enc)
+		if err != nil {
+			t.Fatalf("parseBitString(% x): %v", enc, err)
+		}
+		if got.BitLength != bs.BitLength || !reflect.DeepEqual(got.Bytes, bs.Bytes) {
+			t.Errorf("round trip mismatch: want %+v got %+v", bs, got)
+		}
+	}
+}
+
+func TestMarshalBitStringRejectsBadPadding(t *testing.T) {
+	_, err := marshalBitString(BitStringValue{Bytes: []byte{0x0f}, BitLength: 4})
+	if err == nil {
+		t.Fatal("expected error for non-zero padding bits, got nil")
+	}
+}
+
+// opaqueRoundTripTests covers all four nested types the Opaque special
+// syntax (RFC 2856) can carry.
+var opaqueRoundTripTests = []interface{}{
+	float32(3.5),
+	float64(-2.25),
+	int64(-123456789),
+	uint64(123456789),
+}
+
+func TestOpaqueFloatRoundTrip(t *testing.T) {
+	for _, v := range opaqueRoundTripTests {
+		enc, err := marshalOpaqueFloat(v)
+		if err != nil {
+			t.Fatalf("marshalOpaqueFloat(%v): %v", v, err)
+		}
+		got, err := parseOpaqueFloat(enc)
+		if err != nil {
+			t.Fatalf("parseOpaqueFloat(% x): %v", enc, err)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("round trip mismatch: want %#v (%T) got %#v (%T)", v, v, got, got)
+		}
+	}
+}
+
+func TestParseOpaqueFloatUnknownTag(t *testing.T) {
+	_, err := parseOpaqueFloat([]byte{0x7a, 0x00})
+	if err == nil {
+		t.Fatal("expected error for unknown nested opaque tag, got nil")
+	}
+}
+
+func TestParseOpaqueFloatTruncated(t *testing.T) {
+	_, err := parseOpaqueFloat([]byte{0x78})
+	if err == nil {
+		t.Fatal("expected error for truncated opaque payload, got nil")
+	}
+}
+
+// FuzzMarshalLength checks that every length marshalLength accepts comes
+// back out of parseLength unchanged, tag byte and all.
+func FuzzMarshalLength(f *testing.F) {
+	for _, n := range []int{0, 1, 126, 127, 128, 255, 256, 65535, 65536, 1 << 20} {
+		f.Add(n)
+	}
+	f.Fuzz(func(t *testing.T, n int) {
+		if n < 0 || n > 1<<24 {
+			t.Skip()
+		}
+		enc, err := marshalLength(n)
+		if err != nil {
+			t.Fatalf("marshalLength(%d): %v", n, err)
+		}
+		full := append(append([]byte{0x04}, enc...), make([]byte, n)...)
+		gotLength, cursor, err := parseLength(full)
+		if err != nil {
+			t.Fatalf("parseLength round trip for %d: %v", n, err)
+		}
+		if gotLength != n+1+len(enc) || cursor != 1+len(enc) {
+			t.Fatalf("n=%d: parseLength returned length=%d cursor=%d", n, gotLength, cursor)
+		}
+	})
+}
+
+// FuzzParseLength feeds parseLength arbitrary byte slices: it must never
+// panic, and any length it reports must fit inside the input it was given.
+func FuzzParseLength(f *testing.F) {
+	f.Add([]byte{0x04, 0x00})
+	f.Add([]byte{0x04, 0x03, 'f', 'o', 'o'})
+	f.Add([]byte{0x04, 0x82, 0x01, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		length, cursor, err := parseLength(data)
+		if err != nil {
+			return
+		}
+		if length > len(data) || cursor > length {
+			t.Fatalf("parseLength(% x) = (%d, %d), out of bounds for %d-byte input", data, length, cursor, len(data))
+		}
+	})
+}
+
+// buildGetBulkFixtures returns n BER-encoded values standing in for a
+// GetBulk response's worth of varbinds, cycling through an OID, an
+// OctetString and a Counter32 so the benchmarks below exercise the
+// allocation paths (OID decode, string conversion) the streaming Decoder
+// claims to help with, not just the cheapest fixed-width case.
+func buildGetBulkFixtures(n int) [][]byte {
+	oidContent, err := marshalObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 8072, 3, 2, 10})
+	if err != nil {
+		panic(err)
+	}
+	oidLength, err := marshalLength(len(oidContent))
+	if err != nil {
+		panic(err)
+	}
+	oid := append(append([]byte{0x06}, oidLength...), oidContent...)
+	octetString := []byte{0x04, 0x0b, 'g', 'o', 's', 'n', 'm', 'p', '-', 'a', 'g', 'e', 'n'}
+	counter32 := []byte{0x41, 0x04, 0x00, 0x00, 0x00, 0x2a}
+
+	fixtures := make([][]byte, n)
+	rotation := [][]byte{oid, octetString, counter32}
+	for i := range fixtures {
+		fixtures[i] = rotation[i%len(rotation)]
+	}
+	return fixtures
+}
+
+// buildGetBulkBuffer concatenates fixtures into one buffer of back-to-back
+// encoded values, the shape decodeVarbinds expects to walk.
+func buildGetBulkBuffer(fixtures [][]byte) []byte {
+	var buf []byte
+	for _, f := range fixtures {
+		buf = append(buf, f...)
+	}
+	return buf
+}
+
+// BenchmarkDecodeValueGetBulk10kBaseline decodes 10,000 varbind values per
+// iteration the way callers did before berutil.Decoder grew scratch-reuse
+// readers: one decodeValue call per varbind, each allocating its own
+// Decoder, Variable and (for the OID fixtures) []int.
+func BenchmarkDecodeValueGetBulk10kBaseline(b *testing.B) {
+	fixtures := buildGetBulkFixtures(10000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, data := range fixtures {
+			if _, err := decodeValue(data, "bench"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkDecodeValueGetBulk10kStreaming decodes the same 10,000 varbinds
+// through decodeVarbinds, which walks one Decoder and one DecodeScratch
+// across the whole buffer - the comparison point for the allocation
+// reduction BenchmarkDecodeValueGetBulk10kBaseline pays for above.
+func BenchmarkDecodeValueGetBulk10kStreaming(b *testing.B) {
+	fixtures := buildGetBulkFixtures(10000)
+	buf := buildGetBulkBuffer(fixtures)
+	dst := make([]Variable, len(fixtures))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := decodeVarbinds(buf, dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// objectIdentifierBoundaryTests exercises the X.690 joint-arc split
+// ("2.100.3", where the joint value 40*X1+X2 no longer fits one byte)
+// and the RFC 2578 uint32 sub-identifier ceiling.
+var objectIdentifierBoundaryTests = [][]int{
+	{2, 100, 3},
+	{1, 3, 6, 1, 4, 1, 1<<28 - 1},
+	{1, 3, 6, 1, 4, 1, 1 << 28},
+	{1, 3, 6, 1, 4, 1, 1<<32 - 1},
+}
+
+func TestObjectIdentifierBoundaries(t *testing.T) {
+	for _, oid := range objectIdentifierBoundaryTests {
+		enc, err := marshalObjectIdentifier(oid)
+		if err != nil {
+			t.Fatalf("marshalObjectIdentifier(%v): %v", oid, err)
+		}
+		got, err := parseObjectIdentifier(enc)
+		if err != nil {
+			t.Fatalf("parseObjectIdentifier(% x): %v", enc, err)
+		}
+		if !reflect.DeepEqual(got, oid) {
+			t.Errorf("round trip mismatch: want %v got %v", oid, got)
+		}
+	}
+}
+
+func TestMarshalObjectIdentifierRejectsBadJointArc(t *testing.T) {
+	if _, err := marshalObjectIdentifier([]int{3, 0}); err == nil {
+		t.Fatal("expected error for X1 == 3, got nil")
+	}
+	if _, err := marshalObjectIdentifier([]int{1, 40}); err == nil {
+		t.Fatal("expected error for X1 < 2 with X2 >= 40, got nil")
+	}
+}
+
+// TestParseLengthRejectsOverflowingLongForm reproduces a crafted 8-octet
+// long-form length (0x88, then 8 length octets spelling MaxInt64) that once
+// overflowed the "length += 2 + num_octets" addition back to negative,
+// slipping past the remaining-bytes bounds check and sending a negative
+// length on to callers like parseOpaqueFloat's "data[cursor:length]" slice.
+func TestParseLengthRejectsOverflowingLongForm(t *testing.T) {
+	data := []byte{0x04, 0x88, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if _, _, err := parseLength(data); err == nil {
+		t.Fatal("expected error for overflowing long-form length, got nil")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2013 Sonia Hamilton. All rights reserved.  Use of this source
+// code is governed by a BSD-style license that can be found in the LICENSE
+// file.
+
+// Package berutil provides the generic BER/ASN.1 plumbing shared by the
+// SNMP PDU codec in the parent package. It walks BER tag/length/value
+// headers directly rather than going through encoding/asn1's reflection-
+// based Unmarshal, so a RawValue's Bytes and FullBytes are plain
+// subslices of the input with no copy or heap allocation. It then layers
+// the SNMP application- and context-specific tags (Counter32, Gauge32,
+// TimeTicks, Opaque, Counter64, NoSuchObject, NoSuchInstance,
+// EndOfMibView) on top, since those aren't part of the universal class.
+package berutil
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// BER/ASN.1 tag classes (X.690 section 8.1.2.2), encoded in bits 8-7 of
+// the identifier octet.
+const (
+	ClassUniversal   = 0
+	ClassApplication = 1
+	ClassContext     = 2
+	ClassPrivate     = 3
+)
+
+// SNMP application-class tags (RFC 2578 section 7.1 / RFC 3416 section 3).
+const (
+	TagIPAddress = 0x00
+	TagCounter32 = 0x01
+	TagGauge32   = 0x02
+	TagTimeTicks = 0x03
+	TagOpaque    = 0x04
+	TagCounter64 = 0x06
+)
+
+// SNMP context-class tags used to carry the PDU-level exception values in
+// place of a varbind's value (RFC 3416 section 3).
+const (
+	TagNoSuchObject   = 0x00
+	TagNoSuchInstance = 0x01
+	TagEndOfMibView   = 0x02
+)
+
+// RawValue is a single parsed-but-uninterpreted BER TLV: its class and
+// tag, whether it is constructed, its content octets, and the full
+// encoding (tag + length + content) it occupied in the source buffer.
+// Bytes and FullBytes are subslices of the buffer ParseRawValue was
+// given, so reading one costs no allocation.
+type RawValue struct {
+	Class, Tag int
+	IsCompound bool
+	Bytes      []byte
+	FullBytes  []byte
+}
+
+// ParseRawValue reads a single BER TLV from the front of data and returns
+// it along with whatever followed it. It walks the tag and length octets
+// directly instead of going through encoding/asn1's Unmarshal, which
+// reflects into a destination struct and allocates on every call - on
+// the hot path of a MIB walk that adds up to one allocation per varbind.
+func ParseRawValue(data []byte) (raw RawValue, rest []byte, err error) {
+	if len(data) < 2 {
+		return RawValue{}, nil, fmt.Errorf("berutil: BER header needs at least 2 bytes, got %d", len(data))
+	}
+
+	tagByte := data[0]
+	if tagByte&0x1f == 0x1f {
+		return RawValue{}, nil, errors.New("berutil: high-tag-number form is not supported")
+	}
+
+	contentLen, lengthOctets, err := parseLength(data[1:])
+	if err != nil {
+		return RawValue{}, nil, err
+	}
+
+	headerLen := 1 + lengthOctets
+	// Compare against the remaining buffer before adding headerLen: for an
+	// adversarial long-form length near MaxInt, computing headerLen+contentLen
+	// first would overflow back around to a small or negative total and slip
+	// past a "total > len(data)" check performed afterwards.
+	if contentLen < 0 || contentLen > len(data)-headerLen {
+		return RawValue{}, nil, fmt.Errorf("berutil: length %d exceeds %d remaining bytes", contentLen, len(data)-headerLen)
+	}
+	total := headerLen + contentLen
+
+	raw = RawValue{
+		Class:      int(tagByte>>6) & 0x03,
+		Tag:        int(tagByte & 0x1f),
+		IsCompound: tagByte&0x20 != 0,
+		Bytes:      data[headerLen:total],
+		FullBytes:  data[:total],
+	}
+	return raw, data[total:], nil
+}
+
+// parseLength decodes a BER length header - the bytes immediately after
+// the tag octet - and returns the content length together with how many
+// bytes the length header itself occupied.
+func parseLength(data []byte) (length, octets int, err error) {
+	if len(data) == 0 {
+		return 0, 0, errors.New("berutil: truncated length header")
+	}
+	if data[0] <= 0x7f {
+		return int(data[0]), 1, nil
+	}
+
+	numOctets := int(data[0] & 0x7f)
+	if numOctets == 0 {
+		return 0, 0, errors.New("berutil: indefinite length form is not supported")
+	}
+	if numOctets > 8 {
+		// More octets than fit in an int without overflowing: no real BER
+		// value needs a multi-exabyte length, and letting the shift loop
+		// below run past this would silently wrap length negative.
+		return 0, 0, fmt.Errorf("berutil: long-form length of %d octets too large", numOctets)
+	}
+	if numOctets > len(data)-1 {
+		return 0, 0, errors.New("berutil: truncated long-form length header")
+	}
+	for i := 0; i < numOctets; i++ {
+		length = length<<8 | int(data[1+i])
+	}
+	if length < 0 {
+		return 0, 0, errors.New("berutil: long-form length overflows int")
+	}
+	return length, numOctets + 1, nil
+}
+
+// ParseInt64 decodes data as a big-endian, two's-complement signed
+// integer - the generic ASN.1 INTEGER wire format that SNMP's Integer,
+// TimeTicks and Counter64 tags all reuse. It operates directly on data,
+// a subslice of the original buffer, so it never allocates.
+func ParseInt64(data []byte) (ret int64, err error) {
+	if len(data) > 8 {
+		return 0, errors.New("berutil: integer too large")
+	}
+	for _, b := range data {
+		ret = ret<<8 | int64(b)
+	}
+	// Shift up and down in order to sign extend the result.
+	ret <<= 64 - uint(len(data))*8
+	ret >>= 64 - uint(len(data))*8
+	return ret, nil
+}
+
+// ParseBase128Int decodes one base-128 (VLQ) encoded integer from data
+// starting at offset, returning its value and the offset just past it.
+// Sub-identifiers may use the full uint32 range (RFC 2578), which takes
+// up to 5 base-128 groups (35 bits) to encode; longer encodings are
+// rejected rather than accumulated into a wider type that would silently
+// over-accept past that ceiling.
+func ParseBase128Int(data []byte, initOffset int) (ret, offset int, err error) {
+	offset = initOffset
+	var acc uint64
+	for shifted := 0; offset < len(data); shifted++ {
+		if shifted > 4 {
+			return 0, offset, errors.New("berutil: base 128 integer too large")
+		}
+		b := data[offset]
+		acc = acc<<7 | uint64(b&0x7f)
+		offset++
+		if b&0x80 == 0 {
+			if acc > math.MaxUint32 {
+				return 0, offset, errors.New("berutil: base 128 integer exceeds uint32 range")
+			}
+			return int(acc), offset, nil
+		}
+	}
+	return 0, offset, errors.New("berutil: truncated base 128 integer")
+}
+
+// ParseObjectIdentifier decodes an OBJECT IDENTIFIER's content bytes into
+// dst[:0], reusing dst's backing array when it has enough capacity
+// instead of always allocating a fresh slice - so a caller walking many
+// OIDs (a GetBulk reply's worth, say) can decode all of them through one
+// reused scratch slice.
+func ParseObjectIdentifier(data []byte, dst []int) (s []int, err error) {
+	if len(data) == 0 {
+		return nil, errors.New("berutil: zero length OBJECT IDENTIFIER")
+	}
+
+	// The first subidentifier is the base-128 encoded joint value
+	// 40*X1 + X2, with X1 in {0, 1, 2}: X2 < 40 when X1 < 2, but X1 == 2
+	// lets X2 (and so the joint value) run arbitrarily high, in which
+	// case it no longer fits in a single byte.
+	joint, offset, err := ParseBase128Int(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	s = dst[:0]
+	if joint < 80 {
+		s = append(s, joint/40, joint%40)
+	} else {
+		s = append(s, 2, joint-80)
+	}
+	for offset < len(data) {
+		var v int
+		v, offset, err = ParseBase128Int(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	return s, nil
+}
@@ -0,0 +1,19 @@
+// Copyright 2013 Sonia Hamilton. All rights reserved.  Use of this source
+// code is governed by a BSD-style license that can be found in the LICENSE
+// file.
+
+package berutil
+
+import "testing"
+
+// TestParseRawValueRejectsOverflowingLongForm reproduces a crafted 8-octet
+// long-form length (0x88, then 8 length octets spelling MaxInt64) that once
+// overflowed "headerLen + contentLen" back to a small or negative total,
+// slipping past the "total > len(data)" bounds check and sending an
+// out-of-range total on to the data[headerLen:total] slice below it.
+func TestParseRawValueRejectsOverflowingLongForm(t *testing.T) {
+	data := []byte{0x04, 0x88, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if _, _, err := ParseRawValue(data); err == nil {
+		t.Fatal("expected error for overflowing long-form length, got nil")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2013 Sonia Hamilton. All rights reserved.  Use of this source
+// code is governed by a BSD-style license that can be found in the LICENSE
+// file.
+
+package berutil
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Decoder is a streaming BER reader: it holds a cursor over an
+// in-progress buffer and lets a caller pull one value at a time without
+// reslicing or re-walking bytes it has already consumed. ReadRaw (or
+// NextTag) parks the value it reads as "pending"; ReadInteger/ReadOID
+// then decode that pending value's content, so a caller walking many
+// values - a GetBulk reply's worth, say - can reuse one Decoder and one
+// destination slice across the whole walk instead of allocating fresh
+// ones per value.
+type Decoder struct {
+	data    []byte
+	pending RawValue
+
+	// Debug gates the wireshark-style hex dump every read would
+	// otherwise produce. It defaults to false, so production walks pay
+	// nothing beyond the branch to check it.
+	Debug bool
+}
+
+// NewDecoder returns a Decoder positioned at the start of data.
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{data: data}
+}
+
+// Len reports how many bytes remain unread.
+func (d *Decoder) Len() int {
+	return len(d.data)
+}
+
+// ReadRaw reads and consumes the next value verbatim, leaving the caller
+// to switch on its class/tag. It also parks the value as pending, so
+// ReadInteger/ReadOID can decode its content without re-parsing it.
+func (d *Decoder) ReadRaw() (RawValue, error) {
+	raw, rest, err := ParseRawValue(d.data)
+	if err != nil {
+		return RawValue{}, err
+	}
+	d.debugDump("berutil: read", raw)
+	d.data = rest
+	d.pending = raw
+	return raw, nil
+}
+
+// NextTag reads and consumes the next value's tag/length header, like
+// ReadRaw, but returns only the bits a caller needs to dispatch - class,
+// tag, and whether it's constructed - leaving the content parked as
+// pending for ReadInteger/ReadOID to decode.
+func (d *Decoder) NextTag() (class, tag int, compound bool, err error) {
+	raw, err := d.ReadRaw()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return raw.Class, raw.Tag, raw.IsCompound, nil
+}
+
+// ReadInteger decodes the pending value - set by the most recent ReadRaw
+// or NextTag call - as a big-endian signed integer.
+func (d *Decoder) ReadInteger() (int64, error) {
+	return ParseInt64(d.pending.Bytes)
+}
+
+// ReadOID decodes the pending value as an OBJECT IDENTIFIER into dst[:0],
+// reusing dst's backing array when it has enough capacity instead of
+// allocating a fresh one.
+func (d *Decoder) ReadOID(dst []int) ([]int, error) {
+	return ParseObjectIdentifier(d.pending.Bytes, dst)
+}
+
+// There is deliberately no ReadOctetString(dst []byte): every caller that
+// decodes an OctetString needs an immutable Go string out of it in the
+// end, and string(bytes) always copies regardless of whether bytes came
+// from a reused scratch buffer or straight from d.pending.Bytes - routing
+// through a scratch byte slice first would add an allocation, not remove
+// one.
+
+// debugDump prints a wireshark-style hex dump of raw's full encoding.
+// It's a no-op unless Debug is set.
+func (d *Decoder) debugDump(msg string, raw RawValue) {
+	if !d.Debug {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(msg)
+	for i, c := range raw.FullBytes {
+		if i%8 == 0 {
+			fmt.Fprintf(&b, "\n%3d ", i)
+		}
+		fmt.Fprintf(&b, " %02x", c)
+	}
+	log.Print(b.String())
+}